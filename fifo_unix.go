@@ -49,6 +49,32 @@ func (f *Fifo) Write(b []byte) (n int, err error) {
 	return f.file.Write(b)
 }
 
+// ReadVec reads data into bufs with a single readv(2) syscall, saving
+// callers from having to concatenate fragments into one buffer beforehand.
+func (f *Fifo) ReadVec(bufs [][]byte) (int, error) {
+	n, err := unix.Readv(int(f.file.Fd()), bufs)
+	if err != nil {
+		return n, os.NewSyscallError("readv", err)
+	}
+	return n, nil
+}
+
+// WriteVec writes data from bufs with a single writev(2) syscall, saving
+// callers from having to concatenate fragments into one buffer beforehand.
+func (f *Fifo) WriteVec(bufs [][]byte) (int, error) {
+	n, err := unix.Writev(int(f.file.Fd()), bufs)
+	if err != nil {
+		return n, os.NewSyscallError("writev", err)
+	}
+	return n, nil
+}
+
+// Fd returns the FIFO's file descriptor so that callers can integrate
+// it into their own poll(2)/epoll event loops.
+func (f *Fifo) Fd() uintptr {
+	return f.file.Fd()
+}
+
 func (f *Fifo) Close() error {
 	return f.file.Close()
 }
@@ -0,0 +1,14 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+package shm
+
+// MemoryObject is a shared memory object: a named or anonymous block of
+// memory that can be resized and mapped into a process via mmf.MemoryRegion.
+type MemoryObject interface {
+	Destroy() error
+	Name() string
+	Close() error
+	Truncate(size int64) error
+	Size() int64
+	Fd() uintptr
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+// +build linux
+
+package shm
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// seal bits accepted by NewAnonymousMemoryObject. they map directly onto
+// the F_SEAL_* flags understood by fcntl(F_ADD_SEALS).
+const (
+	SEAL_SHRINK = unix.F_SEAL_SHRINK
+	SEAL_GROW   = unix.F_SEAL_GROW
+	SEAL_WRITE  = unix.F_SEAL_WRITE
+	SEAL_SEAL   = unix.F_SEAL_SEAL
+)
+
+// anonymousMemoryObject is a memfd-backed shared memory object. unlike the
+// path-based memoryObject, it has no name other processes can open: its fd
+// must be handed over explicitly, e.g. via SCM_RIGHTS on a unix socket, or
+// by inheriting it across exec. it needs no DestroyMemoryObject call, as
+// the kernel frees it once the last fd referencing it is closed.
+type anonymousMemoryObject struct {
+	file *os.File
+}
+
+// NewAnonymousMemoryObject creates an anonymous shared memory object of the
+// given size via memfd_create(2). seals is a bitmask of SEAL_* flags
+// applied via fcntl(F_ADD_SEALS) right after the object is sized; sealing
+// SEAL_WRITE (optionally with SEAL_SEAL to prevent further sealing) lets a
+// producer hand out an immutable snapshot.
+//
+// the returned fd is left inheritable on exec, not opened with MFD_CLOEXEC,
+// so that a child process started after the object was created can receive
+// it without going through SCM_RIGHTS. callers that fork/exec unrelated
+// children and want it closed should set FD_CLOEXEC on obj.Fd() themselves.
+func NewAnonymousMemoryObject(size int64, seals int) (MemoryObject, error) {
+	flags := 0
+	if seals != 0 {
+		flags = unix.MFD_ALLOW_SEALING
+	}
+	fd, err := unix.MemfdCreate(fmt.Sprintf("go-ipc-%d", os.Getpid()), flags)
+	if err != nil {
+		return nil, os.NewSyscallError("memfd_create", err)
+	}
+	file := os.NewFile(uintptr(fd), "go-ipc-memfd")
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if seals != 0 {
+		if _, err := unix.FcntlInt(file.Fd(), unix.F_ADD_SEALS, seals); err != nil {
+			file.Close()
+			return nil, os.NewSyscallError("fcntl", err)
+		}
+	}
+	return &anonymousMemoryObject{file: file}, nil
+}
+
+func (obj *anonymousMemoryObject) Destroy() error {
+	return obj.Close()
+}
+
+// Name always returns an empty string: an anonymous memory object has no
+// filesystem entry other processes could look it up by.
+func (obj *anonymousMemoryObject) Name() string {
+	return ""
+}
+
+func (obj *anonymousMemoryObject) Close() error {
+	return obj.file.Close()
+}
+
+func (obj *anonymousMemoryObject) Truncate(size int64) error {
+	return obj.file.Truncate(size)
+}
+
+func (obj *anonymousMemoryObject) Size() int64 {
+	fileInfo, err := obj.file.Stat()
+	if err != nil {
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+func (obj *anonymousMemoryObject) Fd() uintptr {
+	return obj.file.Fd()
+}
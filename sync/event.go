@@ -0,0 +1,102 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+package sync
+
+import (
+	"os"
+	"time"
+)
+
+// EventMode selects how many waiters a Set call wakes. EventAutoReset wakes
+// a single waiter and clears the event again, mirroring a Win32 auto-reset
+// event. EventManualReset wakes every current and future waiter until Reset
+// is called, mirroring a Win32 manual-reset event or a POSIX condvar
+// broadcast.
+type EventMode int
+
+const (
+	EventAutoReset EventMode = iota
+	EventManualReset
+)
+
+// IPCNotifier is implemented by cross-process notification primitives such
+// as Event, so that they compose with the IPCLocker/IPCRWLocker family:
+// shared state can be guarded by a lock and observed through a notifier.
+type IPCNotifier interface {
+	Set()
+	Reset()
+	Wait()
+	WaitTimeout(timeout time.Duration) bool
+}
+
+// all implementations must satisfy IPCNotifier interface.
+var (
+	_ IPCNotifier = (*Event)(nil)
+)
+
+// Event is a named, cross-process notification primitive: the IPC analog
+// of a Win32 named event or a POSIX condition variable with a name.
+type Event struct {
+	impl *eventImpl
+	name string
+}
+
+// NewEvent creates a new named event in the given mode.
+//	name - object name.
+//	flag - flag is a combination of open flags from 'os' package.
+//	perm - object's permission bits.
+//	mode - EventAutoReset or EventManualReset.
+func NewEvent(name string, flag int, perm os.FileMode, mode EventMode) (*Event, error) {
+	impl, err := newEventImpl(name, flag, perm, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{impl: impl, name: name}, nil
+}
+
+// OpenEvent opens an existing named event. its mode was fixed when it was
+// created with NewEvent.
+func OpenEvent(name string) (*Event, error) {
+	impl, err := openEventImpl(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{impl: impl, name: name}, nil
+}
+
+// Set signals the event, waking waiters according to its mode.
+func (e *Event) Set() {
+	e.impl.set()
+}
+
+// Reset clears a previously signaled event in either mode. an auto-reset
+// event clears itself as soon as a waiter wakes, so for it Reset only has an
+// effect if it was set while nobody consumed the signal.
+func (e *Event) Reset() {
+	e.impl.reset()
+}
+
+// Wait blocks until the event is signaled.
+func (e *Event) Wait() {
+	e.impl.wait()
+}
+
+// WaitTimeout blocks until the event is signaled or timeout elapses. it
+// returns true if the event was signaled, and false on timeout.
+func (e *Event) WaitTimeout(timeout time.Duration) bool {
+	return e.impl.waitTimeout(timeout)
+}
+
+// Close indicates, that the object is no longer in use, and that the
+// underlying resources can be freed.
+func (e *Event) Close() error {
+	return e.impl.close()
+}
+
+// Destroy closes the event and removes it permanently.
+func (e *Event) Destroy() error {
+	if err := e.Close(); err != nil {
+		return err
+	}
+	return DestroyEvent(e.name)
+}
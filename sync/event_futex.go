@@ -0,0 +1,143 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+// +build linux freebsd
+
+package sync
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	ipc "bitbucket.org/avd/go-ipc"
+	"bitbucket.org/avd/go-ipc/internal/allocator"
+	"bitbucket.org/avd/go-ipc/mmf"
+	"bitbucket.org/avd/go-ipc/shm"
+
+	"github.com/pkg/errors"
+)
+
+// eventCell is the shared state of an event. generation is bumped on every
+// Set call and is what waiters actually futex-wait on: they record its
+// value before checking signaled, so a Set that lands between the check
+// and the wait still changes generation and aborts the wait instead of
+// being missed. manual records the event's mode, fixed at creation time.
+type eventCell struct {
+	generation uint32
+	signaled   uint32
+	manual     uint32
+}
+
+const eventCellSize = int64(unsafe.Sizeof(eventCell{}))
+
+// eventImpl is the linux/freebsd futex-based implementation of Event.
+type eventImpl struct {
+	cell   *eventCell
+	region *mmf.MemoryRegion
+}
+
+func newEventImpl(name string, flag int, perm os.FileMode, mode EventMode) (*eventImpl, error) {
+	if err := ensureOpenFlags(flag); err != nil {
+		return nil, err
+	}
+	obj, created, resultErr := shm.NewMemoryObjectSize(mutexSharedStateName(name, "ev"), flag, perm, eventCellSize)
+	if resultErr != nil {
+		return nil, errors.Wrap(resultErr, "failed to create shm object")
+	}
+	var region *mmf.MemoryRegion
+	defer func() {
+		obj.Close()
+		if resultErr == nil {
+			return
+		}
+		if region != nil {
+			region.Close()
+		}
+		if created {
+			obj.Destroy()
+		}
+	}()
+	if region, resultErr = mmf.NewMemoryRegion(obj, mmf.MEM_READWRITE, 0, int(eventCellSize)); resultErr != nil {
+		return nil, errors.Wrap(resultErr, "failed to create shm region")
+	}
+	cell := (*eventCell)(unsafe.Pointer(allocator.ByteSliceData(region.Data())))
+	if created {
+		atomic.StoreUint32(&cell.generation, 0)
+		atomic.StoreUint32(&cell.signaled, 0)
+		manual := uint32(0)
+		if mode == EventManualReset {
+			manual = 1
+		}
+		atomic.StoreUint32(&cell.manual, manual)
+	}
+	return &eventImpl{cell: cell, region: region}, nil
+}
+
+func openEventImpl(name string) (*eventImpl, error) {
+	return newEventImpl(name, ipc.O_OPEN_ONLY, 0, EventAutoReset)
+}
+
+func (e *eventImpl) set() {
+	manual := atomic.LoadUint32(&e.cell.manual) != 0
+	atomic.StoreUint32(&e.cell.signaled, 1)
+	atomic.AddUint32(&e.cell.generation, 1)
+	if manual {
+		futexWakeWord(&e.cell.generation, maxWaiters)
+	} else {
+		futexWakeWord(&e.cell.generation, 1)
+	}
+}
+
+func (e *eventImpl) reset() {
+	atomic.StoreUint32(&e.cell.signaled, 0)
+}
+
+func (e *eventImpl) wait() {
+	for {
+		gen := atomic.LoadUint32(&e.cell.generation)
+		if e.tryConsume() {
+			return
+		}
+		futexWaitWord(&e.cell.generation, gen, nil)
+	}
+}
+
+func (e *eventImpl) waitTimeout(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		gen := atomic.LoadUint32(&e.cell.generation)
+		if e.tryConsume() {
+			return true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		futexWaitWord(&e.cell.generation, gen, &remaining)
+	}
+}
+
+// tryConsume reports whether the event is currently signaled, clearing it
+// first if it is an auto-reset event.
+func (e *eventImpl) tryConsume() bool {
+	if atomic.LoadUint32(&e.cell.signaled) == 0 {
+		return false
+	}
+	if atomic.LoadUint32(&e.cell.manual) != 0 {
+		return true
+	}
+	return atomic.CompareAndSwapUint32(&e.cell.signaled, 1, 0)
+}
+
+func (e *eventImpl) close() error {
+	return e.region.Close()
+}
+
+// DestroyEvent permanently removes an event with the given name.
+func DestroyEvent(name string) error {
+	if err := shm.DestroyMemoryObject(mutexSharedStateName(name, "ev")); err != nil {
+		return errors.Wrap(err, "failed to destroy memory object")
+	}
+	return nil
+}
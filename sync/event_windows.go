@@ -0,0 +1,83 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+package sync
+
+import (
+	"os"
+	"time"
+
+	ipc "bitbucket.org/avd/go-ipc"
+
+	"golang.org/x/sys/windows"
+)
+
+// eventImpl is the windows implementation of Event, backed by a Win32
+// named event object.
+type eventImpl struct {
+	handle windows.Handle
+}
+
+func newEventImpl(name string, flag int, perm os.FileMode, mode EventMode) (*eventImpl, error) {
+	namePtr, err := windows.UTF16PtrFromString(eventName(name))
+	if err != nil {
+		return nil, err
+	}
+	var manualReset uint32
+	if mode == EventManualReset {
+		manualReset = 1
+	}
+	var handle windows.Handle
+	switch flag {
+	case ipc.O_OPEN_ONLY:
+		handle, err = windows.OpenEvent(windows.EVENT_ALL_ACCESS, false, namePtr)
+	case ipc.O_CREATE_ONLY:
+		handle, err = windows.CreateEvent(nil, manualReset, 0, namePtr)
+		if handle != 0 && os.IsExist(err) {
+			windows.CloseHandle(handle)
+		}
+	case ipc.O_OPEN_OR_CREATE:
+		handle, err = windows.CreateEvent(nil, manualReset, 0, namePtr)
+		if handle != 0 && os.IsExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &eventImpl{handle: handle}, nil
+}
+
+func openEventImpl(name string) (*eventImpl, error) {
+	return newEventImpl(name, ipc.O_OPEN_ONLY, 0, EventAutoReset)
+}
+
+func (e *eventImpl) set() {
+	windows.SetEvent(e.handle)
+}
+
+func (e *eventImpl) reset() {
+	windows.ResetEvent(e.handle)
+}
+
+func (e *eventImpl) wait() {
+	windows.WaitForSingleObject(e.handle, windows.INFINITE)
+}
+
+func (e *eventImpl) waitTimeout(timeout time.Duration) bool {
+	result, _ := windows.WaitForSingleObject(e.handle, uint32(timeout/time.Millisecond))
+	return result == windows.WAIT_OBJECT_0
+}
+
+func (e *eventImpl) close() error {
+	return windows.CloseHandle(e.handle)
+}
+
+// DestroyEvent is a no-op on windows, as the event object is destroyed
+// when its last handle is closed.
+func DestroyEvent(name string) error {
+	return nil
+}
+
+func eventName(name string) string {
+	return "Global\\" + name
+}
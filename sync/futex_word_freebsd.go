@@ -0,0 +1,63 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+// +build freebsd
+
+package sync
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// freebsd has no futex(2) syscall, but _umtx_op(2) exposes the equivalent
+// UMTX_OP_WAIT_UINT/UMTX_OP_WAKE primitives for a plain uint32 word.
+const (
+	umtxOpWaitUint = 11
+	umtxOpWake     = 3
+)
+
+// umtxTime mirrors freebsd's struct _umtx_time. a timed UMTX_OP_WAIT_UINT
+// takes its timeout this way, not as a bare timespec: uaddr1 must hold
+// sizeof(umtxTime) and uaddr2 must point at a populated one, or the kernel
+// rejects the call with EINVAL.
+type umtxTime struct {
+	timeout unix.Timespec
+	flags   uint32
+	clockID uint32
+}
+
+func futexWaitWord(addr *uint32, old uint32, timeout *time.Duration) error {
+	var uaddr1 uintptr
+	var uaddr2 uintptr
+	if timeout != nil {
+		ut := umtxTime{timeout: unix.NsecToTimespec(timeout.Nanoseconds())}
+		uaddr1 = unsafe.Sizeof(ut)
+		uaddr2 = uintptr(unsafe.Pointer(&ut))
+	}
+	_, _, errno := unix.Syscall6(
+		unix.SYS__UMTX_OP,
+		uintptr(unsafe.Pointer(addr)),
+		uintptr(umtxOpWaitUint),
+		uintptr(old),
+		uaddr1,
+		uaddr2, 0)
+	if errno != 0 && errno != unix.EAGAIN && errno != unix.EINTR && errno != unix.ETIMEDOUT {
+		return errno
+	}
+	return nil
+}
+
+func futexWakeWord(addr *uint32, count int) error {
+	_, _, errno := unix.Syscall6(
+		unix.SYS__UMTX_OP,
+		uintptr(unsafe.Pointer(addr)),
+		uintptr(umtxOpWake),
+		uintptr(count),
+		0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+// +build linux
+
+package sync
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// futexWaitWord blocks the calling goroutine while *addr == old, waiting
+// for not more than timeout (or indefinitely, if timeout is nil).
+func futexWaitWord(addr *uint32, old uint32, timeout *time.Duration) error {
+	var ts *unix.Timespec
+	if timeout != nil {
+		t := unix.NsecToTimespec(timeout.Nanoseconds())
+		ts = &t
+	}
+	_, _, errno := unix.Syscall6(
+		unix.SYS_FUTEX,
+		uintptr(unsafe.Pointer(addr)),
+		uintptr(unix.FUTEX_WAIT),
+		uintptr(old),
+		uintptr(unsafe.Pointer(ts)),
+		0, 0)
+	if errno != 0 && errno != unix.EAGAIN && errno != unix.EINTR && errno != unix.ETIMEDOUT {
+		return errno
+	}
+	return nil
+}
+
+// futexWakeWord wakes at most count goroutines waiting on addr.
+func futexWakeWord(addr *uint32, count int) error {
+	_, _, errno := unix.Syscall6(
+		unix.SYS_FUTEX,
+		uintptr(unsafe.Pointer(addr)),
+		uintptr(unix.FUTEX_WAKE),
+		uintptr(count),
+		0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+// +build linux
+
+package sync
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	stdsync "sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"bitbucket.org/avd/go-ipc/internal/allocator"
+	"bitbucket.org/avd/go-ipc/mmf"
+	"bitbucket.org/avd/go-ipc/shm"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// bits of robustCell.lockWord, mirroring the encoding glibc uses for
+// PTHREAD_MUTEX_ROBUST_NP: the low bits hold the owner's tid, and the top
+// two bits record whether anyone is waiting and whether the owner died.
+const (
+	robustWaiters    uint32 = 1 << 31
+	robustOwnerDied  uint32 = 1 << 30
+	robustTidMask    uint32 = robustOwnerDied - 1
+)
+
+// robustCell is the shared state of a RobustFutexMutex. next links it into
+// the calling process's robust list; the kernel walks that list (via
+// pointers valid in the dying process's own address space) when a thread
+// exits and sets robustOwnerDied on every lock word it still finds held.
+type robustCell struct {
+	next     *robustCell
+	lockWord uint32
+}
+
+// robustFutexOffset is what this process registers as futex_offset in its
+// struct robust_list_head: the distance from a list node to its futex word.
+const robustFutexOffset = int64(unsafe.Offsetof(robustCell{}.lockWord))
+
+// robustListHead mirrors the kernel's struct robust_list_head.
+type robustListHead struct {
+	list          unsafe.Pointer // *robustCell, head of the chain
+	futexOffset   int64
+	listOpPending unsafe.Pointer // *robustCell, set while a link/unlink is in flight
+}
+
+// the kernel tracks one robust list per OS thread (it is what set_robust_list
+// registers and what gets walked at that thread's exit), so the Go-level
+// bookkeeping below is keyed by tid rather than kept as a single process-wide
+// value. callers must pin the calling goroutine to its OS thread with
+// runtime.LockOSThread for as long as they logically hold a robust lock:
+// otherwise the goroutine could migrate to another thread between Lock and
+// Unlock, the tid recorded in the lock word would no longer name the thread
+// actually running the goroutine, and Unlock would reject its own caller.
+//
+// linux recycles tids once a thread exits, so a cache entry must not be
+// trusted to still describe a live, registered thread indefinitely: each
+// entry carries a refcount of the robust locks currently held by that tid,
+// and is dropped as soon as the count reaches zero. since the owning
+// goroutine stays pinned to its OS thread for as long as refs > 0, a
+// non-zero refcount is proof the thread is still the one that registered
+// it; once it drops to zero the thread may go away (or be recycled by the
+// kernel for an unrelated new thread) and the next caller to see that tid
+// re-registers from scratch via set_robust_list, which is safe to repeat.
+type threadRobustState struct {
+	head *robustListHead
+	refs int
+}
+
+var (
+	threadRobustMu     stdsync.Mutex
+	threadRobustStates = map[uint32]*threadRobustState{}
+)
+
+// ensureRobustListRegistered registers the calling OS thread's robust list
+// with the kernel the first time it is used to hold a RobustFutexMutex, and
+// takes a reference on the registration. a failed lock attempt releases it
+// with releaseRobustListRegistration; after a successful one it is held until
+// Unlock releases it. the caller must already be pinned to the OS thread via
+// runtime.LockOSThread.
+func ensureRobustListRegistered(tid uint32) (*robustListHead, error) {
+	threadRobustMu.Lock()
+	defer threadRobustMu.Unlock()
+	if st, ok := threadRobustStates[tid]; ok {
+		st.refs++
+		return st.head, nil
+	}
+	head := &robustListHead{futexOffset: robustFutexOffset}
+	_, _, errno := unix.Syscall(unix.SYS_SET_ROBUST_LIST,
+		uintptr(unsafe.Pointer(head)), unsafe.Sizeof(*head), 0)
+	if errno != 0 {
+		return nil, errors.Wrap(errno, "set_robust_list failed")
+	}
+	threadRobustStates[tid] = &threadRobustState{head: head, refs: 1}
+	return head, nil
+}
+
+// releaseRobustListRegistration drops a reference taken by
+// ensureRobustListRegistered. once the last reference for a tid is
+// released, its cache entry is removed so a later, possibly unrelated
+// thread reusing the same tid is registered fresh rather than silently
+// skipped.
+func releaseRobustListRegistration(tid uint32) {
+	threadRobustMu.Lock()
+	defer threadRobustMu.Unlock()
+	st, ok := threadRobustStates[tid]
+	if !ok {
+		return
+	}
+	st.refs--
+	if st.refs <= 0 {
+		delete(threadRobustStates, tid)
+	}
+}
+
+// linkIntoRobustList and unlinkFromRobustList perform the raw list surgery
+// only; the list_op_pending bracketing that makes the operation visible to
+// the kernel mid-flight is the caller's responsibility (tryAcquireRobust and
+// Unlock), following glibc's protocol of setting list_op_pending *before*
+// attempting the operation rather than after it succeeds.
+func (m *RobustFutexMutex) linkIntoRobustList(head *robustListHead) {
+	m.cell.next = (*robustCell)(atomic.LoadPointer(&head.list))
+	atomic.StorePointer(&head.list, unsafe.Pointer(m.cell))
+}
+
+func (m *RobustFutexMutex) unlinkFromRobustList(head *robustListHead) {
+	var prev *robustCell
+	cur := (*robustCell)(atomic.LoadPointer(&head.list))
+	for cur != nil {
+		if cur == m.cell {
+			if prev == nil {
+				atomic.StorePointer(&head.list, unsafe.Pointer(cur.next))
+			} else {
+				prev.next = cur.next
+			}
+			break
+		}
+		prev, cur = cur, cur.next
+	}
+}
+
+// tryAcquireRobust makes one non-blocking attempt to acquire m for tid. it
+// sets head.listOpPending *before* the CAS that would acquire the lock (not
+// after, like an earlier version of this file did): glibc's robust mutex
+// protocol does the same, specifically so that a crash in the window
+// between the CAS succeeding and the list being updated still leaves the
+// kernel able to find the lock via list_op_pending and mark it
+// FUTEX_OWNER_DIED, instead of silently falling back to the racy
+// tidIsAlive/proc check.
+func (m *RobustFutexMutex) tryAcquireRobust(head *robustListHead, tid uint32) (bool, error) {
+	atomic.StorePointer(&head.listOpPending, unsafe.Pointer(m.cell))
+	defer atomic.StorePointer(&head.listOpPending, nil)
+	old := atomic.LoadUint32(&m.cell.lockWord)
+	owner := old & robustTidMask
+	switch {
+	case owner != 0 && tidIsAlive(owner):
+		// held by a live thread, including one still recovering after an
+		// earlier owner's death.
+	case old&robustOwnerDied != 0 || owner != 0:
+		// marked by the kernel (tid cleared, died bit set), or the recorded
+		// owner no longer exists.
+		if atomic.CompareAndSwapUint32(&m.cell.lockWord, old, tid|robustOwnerDied) {
+			m.linkIntoRobustList(head)
+			return true, &ErrOwnerDied{Mutex: m}
+		}
+	default:
+		if atomic.CompareAndSwapUint32(&m.cell.lockWord, old, tid) {
+			m.linkIntoRobustList(head)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ErrOwnerDied is returned by Lock, TryLock and LockTimeout when the mutex
+// was found locked by a thread that exited without unlocking it. the lock
+// is granted to the caller in an inconsistent state: shared data it guards
+// must be repaired before calling MakeConsistent.
+type ErrOwnerDied struct {
+	Mutex *RobustFutexMutex
+}
+
+func (e *ErrOwnerDied) Error() string {
+	return "go-ipc/sync: owner of the robust mutex died while holding it"
+}
+
+// RobustFutexMutex is a futex-based mutex that detects when its previous
+// owner died while holding the lock, the same guarantee glibc gets from
+// PTHREAD_MUTEX_ROBUST_NP. owner death is found through the kernel's robust
+// list and, on every lock attempt, through a /proc/<tid> liveness check of
+// the recorded owner. if set_robust_list(2) fails, Lock, TryLock and
+// LockTimeout return that error: there is no mode without a robust list.
+type RobustFutexMutex struct {
+	cell   *robustCell
+	region *mmf.MemoryRegion
+	name   string
+}
+
+// NewRobustFutexMutex creates a new robust futex-based mutex.
+//	name - object name.
+//	flag - flag is a combination of open flags from 'os' package.
+//	perm - object's permission bits.
+func NewRobustFutexMutex(name string, flag int, perm os.FileMode) (*RobustFutexMutex, error) {
+	if err := ensureOpenFlags(flag); err != nil {
+		return nil, err
+	}
+	obj, created, resultErr := shm.NewMemoryObjectSize(mutexSharedStateName(name, "rb"), flag, perm, int64(unsafe.Sizeof(robustCell{})))
+	if resultErr != nil {
+		return nil, errors.Wrap(resultErr, "failed to create shm object")
+	}
+	var region *mmf.MemoryRegion
+	defer func() {
+		obj.Close()
+		if resultErr == nil {
+			return
+		}
+		if region != nil {
+			region.Close()
+		}
+		if created {
+			obj.Destroy()
+		}
+	}()
+	if region, resultErr = mmf.NewMemoryRegion(obj, mmf.MEM_READWRITE, 0, int(unsafe.Sizeof(robustCell{}))); resultErr != nil {
+		return nil, errors.Wrap(resultErr, "failed to create shm region")
+	}
+	cell := (*robustCell)(unsafe.Pointer(allocator.ByteSliceData(region.Data())))
+	if created {
+		atomic.StoreUint32(&cell.lockWord, 0)
+	}
+	return &RobustFutexMutex{cell: cell, name: name, region: region}, nil
+}
+
+// Lock locks the mutex, blocking until it becomes available. it returns
+// *ErrOwnerDied if the previous owner died while holding it; the mutex is
+// still acquired in that case.
+//
+// Lock pins the calling goroutine to its OS thread with runtime.LockOSThread,
+// since the owner recorded in the lock word is that thread's tid: the pin is
+// released by the matching Unlock call. callers must call Unlock from the
+// same goroutine that called Lock.
+func (m *RobustFutexMutex) Lock() error {
+	runtime.LockOSThread()
+	tid := uint32(unix.Gettid())
+	head, err := ensureRobustListRegistered(tid)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	for {
+		acquired, acqErr := m.tryAcquireRobust(head, tid)
+		if acquired {
+			return acqErr
+		}
+		old := atomic.LoadUint32(&m.cell.lockWord)
+		if old&robustTidMask == 0 || !tidIsAlive(old&robustTidMask) {
+			continue
+		}
+		atomic.CompareAndSwapUint32(&m.cell.lockWord, old, old|robustWaiters)
+		futexWaitBitset(&m.cell.lockWord, old|robustWaiters, nil)
+	}
+}
+
+// TryLock makes one attempt to lock the mutex. the returned bool reports
+// whether the mutex was acquired; err is *ErrOwnerDied if it was acquired
+// from a dead owner. on success, the calling goroutine is pinned to its OS
+// thread exactly as Lock pins it, and the pin must be released by a matching
+// Unlock call; on failure TryLock leaves the goroutine unpinned.
+func (m *RobustFutexMutex) TryLock() (bool, error) {
+	runtime.LockOSThread()
+	tid := uint32(unix.Gettid())
+	head, err := ensureRobustListRegistered(tid)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return false, err
+	}
+	acquired, acqErr := m.tryAcquireRobust(head, tid)
+	if acquired {
+		return true, acqErr
+	}
+	releaseRobustListRegistration(tid)
+	runtime.UnlockOSThread()
+	return false, nil
+}
+
+// LockTimeout tries to lock the mutex, waiting for not more than timeout.
+// its OS-thread pinning follows the same rule as TryLock: pinned on success,
+// unpinned on failure.
+func (m *RobustFutexMutex) LockTimeout(timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	runtime.LockOSThread()
+	tid := uint32(unix.Gettid())
+	head, err := ensureRobustListRegistered(tid)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return false, err
+	}
+	for {
+		acquired, acqErr := m.tryAcquireRobust(head, tid)
+		if acquired {
+			return true, acqErr
+		}
+		old := atomic.LoadUint32(&m.cell.lockWord)
+		if old&robustTidMask == 0 || !tidIsAlive(old&robustTidMask) {
+			continue
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			releaseRobustListRegistration(tid)
+			runtime.UnlockOSThread()
+			return false, nil
+		}
+		atomic.CompareAndSwapUint32(&m.cell.lockWord, old, old|robustWaiters)
+		futexWaitBitset(&m.cell.lockWord, old|robustWaiters, &remaining)
+	}
+}
+
+// Unlock releases the mutex and undoes the OS-thread pin taken by the Lock
+// (or successful TryLock/LockTimeout) call that acquired it. it panics if
+// the mutex is not locked by the calling thread.
+func (m *RobustFutexMutex) Unlock() {
+	tid := uint32(unix.Gettid())
+	old := atomic.LoadUint32(&m.cell.lockWord)
+	if old&robustTidMask != tid {
+		panic("unlock of a robust mutex not locked by this thread")
+	}
+	threadRobustMu.Lock()
+	st := threadRobustStates[tid]
+	threadRobustMu.Unlock()
+	if st != nil {
+		// bracket the unlink with list_op_pending too, symmetric with
+		// tryAcquireRobust: it covers the window between removing m from the
+		// list and the lock word actually being cleared below.
+		atomic.StorePointer(&st.head.listOpPending, unsafe.Pointer(m.cell))
+		m.unlinkFromRobustList(st.head)
+	}
+	atomic.StoreUint32(&m.cell.lockWord, 0)
+	if old&robustWaiters != 0 {
+		futexWakeWord(&m.cell.lockWord, 1)
+	}
+	if st != nil {
+		atomic.StorePointer(&st.head.listOpPending, nil)
+	}
+	releaseRobustListRegistration(tid)
+	runtime.UnlockOSThread()
+}
+
+// MakeConsistent clears the mutex's inconsistent state after the caller has
+// repaired whatever shared data the mutex protects. it is an error to call
+// it on a mutex that is not currently in the died-owner state.
+func (m *RobustFutexMutex) MakeConsistent() error {
+	old := atomic.LoadUint32(&m.cell.lockWord)
+	if old&robustOwnerDied == 0 {
+		return errors.New("mutex is not in an inconsistent state")
+	}
+	atomic.StoreUint32(&m.cell.lockWord, old&^robustOwnerDied)
+	return nil
+}
+
+// Close indicates, that the object is no longer in use, and that the
+// underlying resources can be freed.
+func (m *RobustFutexMutex) Close() error {
+	return m.region.Close()
+}
+
+// Destroy closes the mutex and removes it permanently.
+func (m *RobustFutexMutex) Destroy() error {
+	if err := m.Close(); err != nil {
+		return errors.Wrap(err, "failed to close shm region")
+	}
+	m.region = nil
+	m.cell = nil
+	return DestroyRobustFutexMutex(m.name)
+}
+
+// DestroyRobustFutexMutex permanently removes a mutex with the given name.
+func DestroyRobustFutexMutex(name string) error {
+	if err := shm.DestroyMemoryObject(mutexSharedStateName(name, "rb")); err != nil {
+		return errors.Wrap(err, "failed to destroy memory object")
+	}
+	return nil
+}
+
+// tidIsAlive reports whether a thread with the given tid is still running.
+// it is checked on every lock attempt alongside the kernel's robust-list
+// tracking: a slower, merely best-effort substitute for the kernel's own
+// owner-death detection.
+func tidIsAlive(tid uint32) bool {
+	if tid == 0 {
+		return false
+	}
+	_, err := os.Stat(fmt.Sprintf("/proc/%d", tid))
+	return err == nil
+}
+
+// futexBitsetMatchAny is FUTEX_BITSET_MATCH_ANY: it makes FUTEX_WAIT_BITSET
+// behave like a plain FUTEX_WAIT with an optional absolute timeout.
+const futexBitsetMatchAny uint32 = 0xffffffff
+
+// futexWaitBitset blocks while *addr == old, using FUTEX_WAIT_BITSET so
+// that the wait can later be extended to target-specific wakeups; for now
+// it is used purely for its timeout support.
+func futexWaitBitset(addr *uint32, old uint32, timeout *time.Duration) error {
+	var ts *unix.Timespec
+	if timeout != nil {
+		// the kernel reads this timeout as an absolute CLOCK_MONOTONIC time.
+		var now unix.Timespec
+		if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+			return err
+		}
+		t := unix.NsecToTimespec(unix.TimespecToNsec(now) + timeout.Nanoseconds())
+		ts = &t
+	}
+	_, _, errno := unix.Syscall6(
+		unix.SYS_FUTEX,
+		uintptr(unsafe.Pointer(addr)),
+		uintptr(unix.FUTEX_WAIT_BITSET),
+		uintptr(old),
+		uintptr(unsafe.Pointer(ts)),
+		0,
+		uintptr(futexBitsetMatchAny))
+	if errno != 0 && errno != unix.EAGAIN && errno != unix.EINTR && errno != unix.ETIMEDOUT {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+package sync
+
+import "time"
+
+// IPCRWLocker is a reader/writer analog of IPCLocker. it allows any number of
+// readers to hold the lock concurrently, but at most one writer, and no
+// readers while a writer holds it. implementations must be safe to use from
+// independent processes, the same way IPCLocker implementations are.
+type IPCRWLocker interface {
+	Lock()
+	Unlock()
+	TryLock() bool
+	LockTimeout(timeout time.Duration) bool
+	RLock()
+	RUnlock()
+	TryRLock() bool
+}
@@ -0,0 +1,248 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+// +build linux freebsd
+
+package sync
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"bitbucket.org/avd/go-ipc/internal/allocator"
+	"bitbucket.org/avd/go-ipc/mmf"
+	"bitbucket.org/avd/go-ipc/shm"
+
+	"github.com/pkg/errors"
+)
+
+// all implementations must satisfy IPCRWLocker interface.
+var (
+	_ IPCRWLocker = (*FutexRWMutex)(nil)
+)
+
+// bits of rwmCell.state. the low 30 bits hold the number of readers
+// currently holding the lock.
+const (
+	rwWriterLocked  uint32 = 1 << 31
+	rwWriterWaiting uint32 = 1 << 30
+	rwReaderMask    uint32 = rwWriterWaiting - 1
+)
+
+// rwmCell is the shared state of a FutexRWMutex. state encodes the
+// writer-held bit, the writer-waiting bit, and the reader count.
+// readersFutex and writerFutex are separate futex words so that readers
+// and writers can be woken independently of one another.
+type rwmCell struct {
+	state        uint32
+	readersFutex uint32
+	writerFutex  uint32
+}
+
+const rwmCellSize = int64(unsafe.Sizeof(rwmCell{}))
+
+// FutexRWMutex is a futex-based reader/writer mutex.
+type FutexRWMutex struct {
+	cell   *rwmCell
+	region *mmf.MemoryRegion
+	name   string
+}
+
+// NewFutexRWMutex creates a new futex-based reader/writer mutex.
+//	name - object name.
+//	flag - flag is a combination of open flags from 'os' package.
+//	perm - object's permission bits.
+func NewFutexRWMutex(name string, flag int, perm os.FileMode) (*FutexRWMutex, error) {
+	if err := ensureOpenFlags(flag); err != nil {
+		return nil, err
+	}
+	obj, created, resultErr := shm.NewMemoryObjectSize(mutexSharedStateName(name, "rw"), flag, perm, rwmCellSize)
+	if resultErr != nil {
+		return nil, errors.Wrap(resultErr, "failed to create shm object")
+	}
+	var region *mmf.MemoryRegion
+	defer func() {
+		obj.Close()
+		if resultErr == nil {
+			return
+		}
+		if region != nil {
+			region.Close()
+		}
+		if created {
+			obj.Destroy()
+		}
+	}()
+	if region, resultErr = mmf.NewMemoryRegion(obj, mmf.MEM_READWRITE, 0, int(rwmCellSize)); resultErr != nil {
+		return nil, errors.Wrap(resultErr, "failed to create shm region")
+	}
+	cell := (*rwmCell)(unsafe.Pointer(allocator.ByteSliceData(region.Data())))
+	if created {
+		atomic.StoreUint32(&cell.state, 0)
+		atomic.StoreUint32(&cell.readersFutex, 0)
+		atomic.StoreUint32(&cell.writerFutex, 0)
+	}
+	return &FutexRWMutex{cell: cell, name: name, region: region}, nil
+}
+
+// Lock locks m for writing. if the lock is already held for reading or
+// writing, Lock blocks until the lock is available.
+func (m *FutexRWMutex) Lock() {
+	for {
+		// the futex word must be captured before the state check below, so
+		// that a concurrent Unlock/RUnlock which bumps it in between is not
+		// missed: futexWaitWord then sees a stale word and returns instead
+		// of blocking forever. see event_futex.go's wait() for the same
+		// pattern.
+		word := atomic.LoadUint32(&m.cell.writerFutex)
+		state := atomic.LoadUint32(&m.cell.state)
+		if state&rwWriterLocked == 0 && state&rwReaderMask == 0 {
+			if atomic.CompareAndSwapUint32(&m.cell.state, state, state|rwWriterLocked) {
+				return
+			}
+			continue
+		}
+		atomic.CompareAndSwapUint32(&m.cell.state, state, state|rwWriterWaiting)
+		futexWaitWord(&m.cell.writerFutex, word, nil)
+	}
+}
+
+// TryLock makes one attempt to lock m for writing. it returns true on
+// success, and false if the lock is currently held by anyone.
+func (m *FutexRWMutex) TryLock() bool {
+	state := atomic.LoadUint32(&m.cell.state)
+	if state&rwWriterLocked != 0 || state&rwReaderMask != 0 {
+		return false
+	}
+	return atomic.CompareAndSwapUint32(&m.cell.state, state, state|rwWriterLocked)
+}
+
+// LockTimeout tries to lock m for writing, waiting for not more than timeout.
+func (m *FutexRWMutex) LockTimeout(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		word := atomic.LoadUint32(&m.cell.writerFutex)
+		if m.TryLock() {
+			return true
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			m.clearWriterWaiting()
+			return false
+		}
+		state := atomic.LoadUint32(&m.cell.state)
+		atomic.CompareAndSwapUint32(&m.cell.state, state, state|rwWriterWaiting)
+		futexWaitWord(&m.cell.writerFutex, word, &remaining)
+	}
+}
+
+// clearWriterWaiting drops the writer-waiting bit when a timed-out Lock
+// attempt gives up, so that readers are not starved forever by a writer
+// that backed off. any other writer still waiting is woken as well and
+// re-sets the bit on its own next loop iteration, so this is safe even when
+// contested.
+func (m *FutexRWMutex) clearWriterWaiting() {
+	for {
+		state := atomic.LoadUint32(&m.cell.state)
+		if state&rwWriterWaiting == 0 {
+			return
+		}
+		if atomic.CompareAndSwapUint32(&m.cell.state, state, state&^rwWriterWaiting) {
+			atomic.AddUint32(&m.cell.readersFutex, 1)
+			futexWakeWord(&m.cell.readersFutex, maxWaiters)
+			atomic.AddUint32(&m.cell.writerFutex, 1)
+			futexWakeWord(&m.cell.writerFutex, maxWaiters)
+			return
+		}
+	}
+}
+
+// Unlock unlocks m for writing. it panics if m is not locked for writing.
+func (m *FutexRWMutex) Unlock() {
+	state := atomic.LoadUint32(&m.cell.state)
+	if state&rwWriterLocked == 0 {
+		panic("unlock of unlocked mutex")
+	}
+	atomic.AddUint32(&m.cell.writerFutex, 1)
+	atomic.AddUint32(&m.cell.readersFutex, 1)
+	newState := state &^ (rwWriterLocked | rwWriterWaiting)
+	atomic.StoreUint32(&m.cell.state, newState)
+	futexWakeWord(&m.cell.writerFutex, 1)
+	futexWakeWord(&m.cell.readersFutex, maxWaiters)
+}
+
+// RLock locks m for reading. it blocks while a writer holds the lock or is
+// waiting for it, so that writers are not starved by a steady stream of
+// readers.
+func (m *FutexRWMutex) RLock() {
+	for {
+		// see Lock: the futex word must be read before the state check.
+		word := atomic.LoadUint32(&m.cell.readersFutex)
+		state := atomic.LoadUint32(&m.cell.state)
+		if state&(rwWriterLocked|rwWriterWaiting) != 0 {
+			futexWaitWord(&m.cell.readersFutex, word, nil)
+			continue
+		}
+		if atomic.CompareAndSwapUint32(&m.cell.state, state, state+1) {
+			return
+		}
+	}
+}
+
+// TryRLock makes one attempt to lock m for reading. it returns false if a
+// writer currently holds the lock or is waiting for it.
+func (m *FutexRWMutex) TryRLock() bool {
+	state := atomic.LoadUint32(&m.cell.state)
+	if state&(rwWriterLocked|rwWriterWaiting) != 0 {
+		return false
+	}
+	return atomic.CompareAndSwapUint32(&m.cell.state, state, state+1)
+}
+
+// RUnlock undoes a single RLock call. the last reader out wakes a pending
+// writer, if any.
+func (m *FutexRWMutex) RUnlock() {
+	for {
+		state := atomic.LoadUint32(&m.cell.state)
+		if state&rwReaderMask == 0 {
+			panic("runlock of unlocked mutex")
+		}
+		newState := state - 1
+		if !atomic.CompareAndSwapUint32(&m.cell.state, state, newState) {
+			continue
+		}
+		if newState&rwReaderMask == 0 && newState&rwWriterWaiting != 0 {
+			atomic.AddUint32(&m.cell.writerFutex, 1)
+			futexWakeWord(&m.cell.writerFutex, 1)
+		}
+		return
+	}
+}
+
+// Close indicates, that the object is no longer in use, and that the
+// underlying resources can be freed.
+func (m *FutexRWMutex) Close() error {
+	return m.region.Close()
+}
+
+// Destroy closes the mutex and removes it permanently.
+func (m *FutexRWMutex) Destroy() error {
+	if err := m.Close(); err != nil {
+		return errors.Wrap(err, "failed to close shm region")
+	}
+	m.region = nil
+	m.cell = nil
+	return DestroyFutexRWMutex(m.name)
+}
+
+// DestroyFutexRWMutex permanently removes a mutex with the given name.
+func DestroyFutexRWMutex(name string) error {
+	if err := shm.DestroyMemoryObject(mutexSharedStateName(name, "rw")); err != nil {
+		return errors.Wrap(err, "failed to destroy memory object")
+	}
+	return nil
+}
+
+// maxWaiters is used as a 'wake everyone' count for FUTEX_WAKE-style calls.
+const maxWaiters = 1<<31 - 1
@@ -0,0 +1,242 @@
+// Copyright 2016 Aleksandr Demakin. All rights reserved.
+
+package sync
+
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"bitbucket.org/avd/go-ipc/internal/allocator"
+	"bitbucket.org/avd/go-ipc/mmf"
+	"bitbucket.org/avd/go-ipc/shm"
+
+	"github.com/pkg/errors"
+)
+
+// all implementations must satisfy IPCRWLocker interface.
+var (
+	_ IPCRWLocker = (*SemaRWMutex)(nil)
+)
+
+// rwCounters is the shared state of a SemaRWMutex: the number of readers
+// currently holding the lock, and the number of writers currently waiting
+// for or holding it.
+type rwCounters struct {
+	readers uint32
+	writers uint32
+}
+
+const rwCountersSize = int64(unsafe.Sizeof(rwCounters{}))
+
+// SemaRWMutex is a semaphore-based reader/writer mutex for systems without
+// a native futex. it implements the classical writer-priority algorithm:
+// once a writer registers its intent, new readers block until all pending
+// writers have run.
+type SemaRWMutex struct {
+	name       string
+	region     *mmf.MemoryRegion
+	counters   *rwCounters
+	readersMtx *SemaMutex // guards counters.readers
+	writersMtx *SemaMutex // guards counters.writers
+	r          *SemaMutex // held while at least one writer is pending or active; blocks new readers
+	w          *SemaMutex // held by whoever currently has access: the writer, or the first reader
+}
+
+// NewSemaRWMutex creates a new semaphore-based reader/writer mutex.
+//	name - object name.
+//	flag - flag is a combination of open flags from 'os' package.
+//	perm - object's permission bits.
+func NewSemaRWMutex(name string, flag int, perm os.FileMode) (*SemaRWMutex, error) {
+	if err := ensureOpenFlags(flag); err != nil {
+		return nil, err
+	}
+	region, created, err := createWritableRegion(mutexSharedStateName(name, "rw"), flag, perm, int(rwCountersSize), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create shared state")
+	}
+	result := &SemaRWMutex{
+		name:     name,
+		region:   region,
+		counters: (*rwCounters)(unsafe.Pointer(allocator.ByteSliceData(region.Data()))),
+	}
+	if result.readersMtx, err = NewSemaMutex(name+"-rdmx", flag, perm); err != nil {
+		result.closePartial()
+		return nil, errors.Wrap(err, "failed to create readers mutex")
+	}
+	if result.writersMtx, err = NewSemaMutex(name+"-wrmx", flag, perm); err != nil {
+		result.closePartial()
+		return nil, errors.Wrap(err, "failed to create writers mutex")
+	}
+	if result.r, err = NewSemaMutex(name+"-r", flag, perm); err != nil {
+		result.closePartial()
+		return nil, errors.Wrap(err, "failed to create readers gate")
+	}
+	if result.w, err = NewSemaMutex(name+"-w", flag, perm); err != nil {
+		result.closePartial()
+		return nil, errors.Wrap(err, "failed to create writers gate")
+	}
+	if created {
+		result.counters.readers = 0
+		result.counters.writers = 0
+	}
+	return result, nil
+}
+
+// closePartial closes whichever sub-objects have already been created.
+// it is only used to unwind a failed constructor call.
+func (m *SemaRWMutex) closePartial() {
+	if m.w != nil {
+		m.w.Close()
+	}
+	if m.r != nil {
+		m.r.Close()
+	}
+	if m.writersMtx != nil {
+		m.writersMtx.Close()
+	}
+	if m.readersMtx != nil {
+		m.readersMtx.Close()
+	}
+	m.region.Close()
+}
+
+// Lock locks m for writing, blocking until no reader or writer holds it.
+func (m *SemaRWMutex) Lock() {
+	m.writersMtx.Lock()
+	m.counters.writers++
+	if m.counters.writers == 1 {
+		m.r.Lock()
+	}
+	m.writersMtx.Unlock()
+	m.w.Lock()
+}
+
+// TryLock makes one attempt to lock m for writing.
+func (m *SemaRWMutex) TryLock() bool {
+	m.writersMtx.Lock()
+	first := m.counters.writers == 0
+	if first {
+		if !m.r.TryLock() {
+			m.writersMtx.Unlock()
+			return false
+		}
+	}
+	m.counters.writers++
+	m.writersMtx.Unlock()
+	if m.w.TryLock() {
+		return true
+	}
+	m.writersMtx.Lock()
+	m.counters.writers--
+	if m.counters.writers == 0 {
+		m.r.Unlock()
+	}
+	m.writersMtx.Unlock()
+	return false
+}
+
+// LockTimeout tries to lock m for writing, waiting for not more than timeout.
+func (m *SemaRWMutex) LockTimeout(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if m.TryLock() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Unlock unlocks m for writing.
+func (m *SemaRWMutex) Unlock() {
+	m.w.Unlock()
+	m.writersMtx.Lock()
+	m.counters.writers--
+	if m.counters.writers == 0 {
+		m.r.Unlock()
+	}
+	m.writersMtx.Unlock()
+}
+
+// RLock locks m for reading. it blocks while a writer holds the lock or is
+// waiting for it, so that writers are not starved by a steady stream of
+// readers.
+func (m *SemaRWMutex) RLock() {
+	m.r.Lock()
+	m.readersMtx.Lock()
+	m.counters.readers++
+	if m.counters.readers == 1 {
+		m.w.Lock()
+	}
+	m.readersMtx.Unlock()
+	m.r.Unlock()
+}
+
+// TryRLock makes one attempt to lock m for reading.
+func (m *SemaRWMutex) TryRLock() bool {
+	if !m.r.TryLock() {
+		return false
+	}
+	m.readersMtx.Lock()
+	first := m.counters.readers == 0
+	if first && !m.w.TryLock() {
+		m.readersMtx.Unlock()
+		m.r.Unlock()
+		return false
+	}
+	m.counters.readers++
+	m.readersMtx.Unlock()
+	m.r.Unlock()
+	return true
+}
+
+// RUnlock undoes a single RLock call. the last reader out releases the
+// writers' gate.
+func (m *SemaRWMutex) RUnlock() {
+	m.readersMtx.Lock()
+	m.counters.readers--
+	if m.counters.readers == 0 {
+		m.w.Unlock()
+	}
+	m.readersMtx.Unlock()
+}
+
+// Close closes shared state of the mutex.
+func (m *SemaRWMutex) Close() error {
+	var firstErr error
+	for _, c := range []interface {
+		Close() error
+	}{m.w, m.r, m.writersMtx, m.readersMtx} {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := m.region.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Destroy closes the mutex and removes it permanently.
+func (m *SemaRWMutex) Destroy() error {
+	if err := m.Close(); err != nil {
+		return errors.Wrap(err, "failed to close shared state")
+	}
+	return DestroySemaRWMutex(m.name)
+}
+
+// DestroySemaRWMutex permanently removes a mutex with the given name.
+func DestroySemaRWMutex(name string) error {
+	if err := shm.DestroyMemoryObject(mutexSharedStateName(name, "rw")); err != nil {
+		return errors.Wrap(err, "failed to destroy shared state")
+	}
+	for _, suffix := range []string{"-rdmx", "-wrmx", "-r", "-w"} {
+		if err := DestroySemaMutex(name + suffix); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+	}
+	return nil
+}